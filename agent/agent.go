@@ -18,7 +18,10 @@ import (
 	"github.com/ngaut/log"
 
 	uconf "udup/config"
+	"udup/discover"
 	"udup/plugins"
+	"udup/telemetry"
+	"udup/wal"
 )
 
 var (
@@ -27,9 +30,11 @@ var (
 )
 
 const (
-	serfSnapshot       = "serf/snapshot"
-	defaultRecoverTime = 10 * time.Second
-	defaultLeaderTTL   = 20 * time.Second
+	serfSnapshot           = "serf/snapshot"
+	defaultRecoverTime     = 10 * time.Second
+	defaultLeaderTTL       = 20 * time.Second
+	defaultRetryInterval   = 30 * time.Second
+	defaultRetryBackoffCap = 5 * time.Minute
 )
 
 type Agent struct {
@@ -40,11 +45,30 @@ type Agent struct {
 	sched     *Scheduler
 	candidate *leadership.Candidate
 	ready     bool
+	delegate  *delegate
+
+	// triggerCh requests an out-of-cycle anti-entropy reconciliation pass;
+	// leaderCh reports leadership transitions to the reconcile loop.
+	triggerCh chan struct{}
+	leaderCh  chan bool
+	// leading mirrors the latest value sent on leaderCh; accessed
+	// atomically so callers outside the reconcile loop can check
+	// leadership (e.g. before replaying the WAL to a reconnected node).
+	leading int32
+
+	// wal records outbound job dispatch queries so they can be replayed
+	// to a node that missed them during a partition.
+	wal *wal.WAL
+
+	// heartbeat records the last time eventLoop made progress, so a
+	// Supervisor can tell a wedged event loop from a healthy but idle one.
+	heartbeatMu sync.Mutex
+	heartbeat   time.Time
 
 	ProcessorPlugins map[string]string
 	shutdown         bool
 	shutdownCh       chan struct{}
-	shutdownLock     sync.Mutex
+	shutdownOnce     sync.Once
 }
 
 // NewAgent is used to create a new agent with the given configuration
@@ -54,6 +78,10 @@ func NewAgent(config *uconf.Config) (*Agent, error) {
 		shutdownCh: make(chan struct{}),
 	}
 
+	if err := telemetry.Init(a.config.Telemetry); err != nil {
+		return nil, fmt.Errorf("Failed to start telemetry: %v", err)
+	}
+
 	// Initialize the wan Serf
 	var err error
 	a.serf, err = a.setupSerf()
@@ -62,6 +90,9 @@ func NewAgent(config *uconf.Config) (*Agent, error) {
 		return nil, fmt.Errorf("Failed to start serf: %v", err)
 	}
 	a.join(a.config.StartJoin, true)
+	if len(a.config.RetryJoin) > 0 {
+		go a.retryJoin()
+	}
 
 	if err := a.setupDrivers(); err != nil {
 		return nil, fmt.Errorf("Failed to setup drivers: %v", err)
@@ -70,10 +101,26 @@ func NewAgent(config *uconf.Config) (*Agent, error) {
 	if a.config.Server {
 		a.store = NewStore(a.config.Consul.Addrs, a)
 		a.sched = NewScheduler()
+		a.triggerCh = make(chan struct{}, 1)
+		a.leaderCh = make(chan bool, 1)
+
+		w, err := wal.Open(filepath.Join(a.config.DataDir, "wal"))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to open WAL: %v", err)
+		}
+		a.wal = w
+		go a.walCompactLoop()
 
 		a.ServeHTTP()
 		listenRPC(a)
+		registerKeyringHTTP(a)
+		if err := registerKeyringRPC(a); err != nil {
+			return nil, fmt.Errorf("Failed to register keyring RPC endpoints: %v", err)
+		}
+		registerMetricsHTTP(a)
+		registerJobStatesHTTP(a)
 		a.participate()
+		go a.reconcile()
 	}
 	go a.eventLoop()
 	a.ready = true
@@ -138,6 +185,23 @@ func (a *Agent) setupSerf() (*serf.Serf, error) {
 	serfConfig.EnableNameConflictResolution = !a.config.DisableNameResolution
 	serfConfig.RejoinAfterLeave = a.config.RejoinAfterLeave
 
+	if a.config.EncryptKey != "" {
+		key, err := decodeEncryptKey(a.config.EncryptKey)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid encryption key: %v", err)
+		}
+		serfConfig.MemberlistConfig.SecretKey = key
+	}
+	if a.config.KeyringFile != "" {
+		serfConfig.KeyringFile = a.config.KeyringFile
+		if err := validateKeyringFile(a.config.KeyringFile); err != nil {
+			return nil, fmt.Errorf("Invalid keyring file: %v", err)
+		}
+	}
+
+	a.delegate = newDelegate(a.config.NodeName)
+	serfConfig.MemberlistConfig.Delegate = a.delegate
+
 	// Create a channel to listen for events from Serf
 	a.eventCh = make(chan serf.Event, 64)
 	serfConfig.EventCh = a.eventCh
@@ -259,6 +323,74 @@ func (a *Agent) join(addrs []string, replay bool) (n int, err error) {
 	return
 }
 
+// retryJoin resolves the configured retry_join addresses, including any
+// backed by a cloud discovery provider, and keeps retrying with backoff
+// until at least one node is reached, or until RetryMaxAttempts attempts
+// have failed when that is set. With no attempt limit it keeps trying for
+// the life of the agent, so a server that comes up before the rest of an
+// autoscaling group still joins once peers appear.
+func (a *Agent) retryJoin() {
+	for attempt := 0; ; attempt++ {
+		addrs, err := a.resolveJoinAddrs()
+		if err != nil {
+			log.Errorf("agent: Failed to resolve retry_join addresses: %v", err)
+		} else if len(addrs) > 0 {
+			if n, err := a.join(addrs, true); err == nil && n > 0 {
+				return
+			}
+		}
+
+		if a.config.RetryMaxAttempts > 0 && attempt+1 >= a.config.RetryMaxAttempts {
+			log.Errorf("agent: max retry-join attempts reached, giving up")
+			return
+		}
+
+		base := a.config.RetryInterval
+		if base == 0 {
+			base = defaultRetryInterval
+		}
+		interval := retryJoinBackoff(base, attempt)
+		log.Warnf("agent: Retrying join in %v", interval)
+		time.Sleep(interval)
+	}
+}
+
+// retryJoinBackoff doubles base for each failed attempt (attempt 0 is the
+// first retry, so it waits one base interval), capped so a long-partitioned
+// agent doesn't end up waiting hours between tries.
+func retryJoinBackoff(base time.Duration, attempt int) time.Duration {
+	interval := base * time.Duration(1<<uint(attempt))
+	if interval <= 0 || interval > defaultRetryBackoffCap {
+		return defaultRetryBackoffCap
+	}
+	return interval
+}
+
+// resolveJoinAddrs expands the configured retry_join entries into concrete
+// addresses, resolving any "provider=..." entry through the matching
+// discover.Provider.
+func (a *Agent) resolveJoinAddrs() ([]string, error) {
+	var addrs []string
+	for _, j := range a.config.RetryJoin {
+		if !discover.IsDiscover(j) {
+			addrs = append(addrs, j)
+			continue
+		}
+
+		args := discover.Parse(j)
+		p, ok := discover.Get(args["provider"])
+		if !ok {
+			return nil, fmt.Errorf("unsupported discovery provider %q", args["provider"])
+		}
+		found, err := p.Addrs(args)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, found...)
+	}
+	return addrs, nil
+}
+
 // Utility method to get leader nodename
 func (a *Agent) leaderMember() (*serf.Member, error) {
 	leaderName := a.store.GetLeader()
@@ -287,8 +419,16 @@ func (a *Agent) listServers() []serf.Member {
 func (a *Agent) eventLoop() {
 	serfShutdownCh := a.serf.ShutdownCh()
 	log.Info("agent: Listen for events")
+
+	heartbeat := time.NewTicker(time.Second)
+	defer heartbeat.Stop()
+	a.recordHeartbeat()
+
 	for {
 		select {
+		case <-heartbeat.C:
+			a.recordHeartbeat()
+
 		case e := <-a.eventCh:
 			log.Infof("agent: Received event: %v", e.String())
 
@@ -297,10 +437,18 @@ func (a *Agent) eventLoop() {
 				for _, member := range failed.Members {
 					log.Debug("agent: Member event: %v; Node:%v; Member:%v.", e.EventType(), a.config.NodeName, member.Name)
 				}
+				telemetry.IncrCounter([]string{"serf", "event", e.EventType().String()}, float32(len(failed.Members)))
+
+				if a.config.Server && a.isLeader() && e.EventType() == serf.EventMemberJoin {
+					for _, member := range failed.Members {
+						go a.replayWAL(member.Name)
+					}
+				}
 			}
 
 			if e.EventType() == serf.EventQuery {
 				query := e.(*serf.Query)
+				telemetry.IncrCounter([]string{"agent", "query", query.Name}, 1)
 
 				switch query.Name {
 				case QuerySchedulerRestart:
@@ -384,28 +532,43 @@ func (a *Agent) eventLoop() {
 
 // invokeJob will execute the given job. Depending on the event.
 func (a *Agent) invokeJob(job *Job) error {
+	defer telemetry.MeasureSince([]string{"agent", "invoke_job"}, time.Now())
 	job.Success = true
 
 	rpcServer, err := a.queryRPCConfig(job.NodeName)
 	if err != nil {
+		telemetry.IncrCounter([]string{"agent", "invoke_job", "error"}, 1)
 		return err
 	}
 
 	rc := &RPCClient{ServerAddr: string(rpcServer)}
-	return rc.callRunJob(job)
+	if err := rc.callRunJob(job); err != nil {
+		telemetry.IncrCounter([]string{"agent", "invoke_job", "error"}, 1)
+		a.delegate.setLocalJobState(job.Name, false, -1, time.Now().Unix())
+		return err
+	}
+	a.delegate.setLocalJobState(job.Name, true, 0, time.Now().Unix())
+	return nil
 }
 
 // invokeJob will execute the given job. Depending on the event.
 func (a *Agent) stopJob(job *Job) error {
+	defer telemetry.MeasureSince([]string{"agent", "stop_job"}, time.Now())
 	job.Success = true
 
 	rpcServer, err := a.queryRPCConfig(job.NodeName)
 	if err != nil {
+		telemetry.IncrCounter([]string{"agent", "stop_job", "error"}, 1)
 		return err
 	}
 
 	rc := &RPCClient{ServerAddr: string(rpcServer)}
-	return rc.callStopJob(job)
+	if err := rc.callStopJob(job); err != nil {
+		telemetry.IncrCounter([]string{"agent", "stop_job", "error"}, 1)
+		return err
+	}
+	a.delegate.setLocalJobState(job.Name, false, 0, time.Now().Unix())
+	return nil
 }
 
 func (a *Agent) participate() {
@@ -414,8 +577,13 @@ func (a *Agent) participate() {
 	go func() {
 		for {
 			a.runForElection()
-			// retry
-			time.Sleep(defaultRecoverTime)
+
+			select {
+			case <-a.shutdownCh:
+				return
+			case <-time.After(defaultRecoverTime):
+				// retry
+			}
 		}
 	}()
 }
@@ -430,6 +598,7 @@ func (a *Agent) runForElection() {
 		case isElected := <-electedCh:
 			if isElected {
 				log.Info("agent: Cluster leadership acquired")
+				telemetry.IncrCounter([]string{"agent", "leader", "elected"}, 1)
 				// If this server is elected as the leader, start the scheduler
 				log.Debug("agent: Restarting scheduler")
 				jobs, err := a.store.GetJobs()
@@ -437,10 +606,13 @@ func (a *Agent) runForElection() {
 					log.Fatal(err)
 				}
 				a.sched.Restart(jobs)
+				a.notifyLeaderChange(true)
 			} else {
 				log.Info("agent: Cluster leadership lost")
+				telemetry.IncrCounter([]string{"agent", "leader", "lost"}, 1)
 				// Always stop the schedule of this server to prevent multiple servers with the scheduler on
 				a.sched.Stop()
+				a.notifyLeaderChange(false)
 			}
 
 		case err := <-errCh:
@@ -452,6 +624,46 @@ func (a *Agent) runForElection() {
 	}
 }
 
+// recordHeartbeat stamps the time eventLoop last made progress, so a
+// Supervisor can detect a wedged event loop.
+func (a *Agent) recordHeartbeat() {
+	a.heartbeatMu.Lock()
+	a.heartbeat = time.Now()
+	a.heartbeatMu.Unlock()
+
+	telemetry.SetGauge([]string{"serf", "members"}, float32(len(a.serf.Members())))
+	if a.config.Server {
+		leaderKnown := float32(0)
+		if _, err := a.leaderMember(); err == nil {
+			leaderKnown = 1
+		}
+		telemetry.SetGauge([]string{"agent", "leader", "known"}, leaderKnown)
+	}
+}
+
+// lastHeartbeat returns the last time eventLoop recorded progress.
+func (a *Agent) lastHeartbeat() time.Time {
+	a.heartbeatMu.Lock()
+	defer a.heartbeatMu.Unlock()
+	return a.heartbeat
+}
+
+// JobStates returns the cluster-wide job-state view gossiped by every
+// node's memberlist delegate, keyed by node name and then job name.
+func (a *Agent) JobStates() map[string]map[string]jobStateSummary {
+	if a.delegate == nil {
+		return nil
+	}
+	return a.delegate.jobStates()
+}
+
+// Metrics returns the in-memory telemetry snapshot backing the
+// /debug/metrics HTTP endpoint. It is nil when an external sink is
+// configured or telemetry is disabled.
+func (a *Agent) Metrics() map[string]interface{} {
+	return telemetry.DisplayMetrics()
+}
+
 // This function is called when a client request the RPCAddress
 // of the current member.
 func (a *Agent) getRPCAddr() string {
@@ -460,19 +672,36 @@ func (a *Agent) getRPCAddr() string {
 	return fmt.Sprintf("%s:%d", bindIp, a.config.RPCPort)
 }
 
-// Shutdown is used to terminate the agent.
+// Shutdown is used to terminate the agent. It is safe to call multiple
+// times, and from multiple goroutines (participate()'s election loop and
+// eventLoop can both want to shut the agent down); only the first call
+// has any effect.
 func (a *Agent) Shutdown() error {
-	a.shutdownLock.Lock()
-	defer a.shutdownLock.Unlock()
+	a.shutdownOnce.Do(func() {
+		log.Infof("agent: requesting shutdown")
 
-	if a.shutdown {
-		return nil
-	}
+		if a.candidate != nil {
+			a.candidate.Resign()
+		}
 
-	log.Infof("agent: requesting shutdown")
+		if a.serf != nil {
+			if err := a.serf.Leave(); err != nil {
+				log.Errorf("agent: error leaving serf cluster: %v", err)
+			}
+			if err := a.serf.Shutdown(); err != nil {
+				log.Errorf("agent: error shutting down serf: %v", err)
+			}
+		}
+
+		if a.wal != nil {
+			if err := a.wal.Close(); err != nil {
+				log.Errorf("agent: error closing wal: %v", err)
+			}
+		}
 
-	log.Infof("agent: shutdown complete")
-	a.shutdown = true
-	close(a.shutdownCh)
+		a.shutdown = true
+		close(a.shutdownCh)
+		log.Infof("agent: shutdown complete")
+	})
 	return nil
 }
@@ -0,0 +1,38 @@
+package agent
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryJoinBackoffDoublesPerAttempt(t *testing.T) {
+	base := 1 * time.Second
+	cases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, 1 * time.Second},
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{3, 8 * time.Second},
+	}
+	for _, c := range cases {
+		if got := retryJoinBackoff(base, c.attempt); got != c.want {
+			t.Errorf("retryJoinBackoff(%v, %d) = %v, want %v", base, c.attempt, got, c.want)
+		}
+	}
+}
+
+func TestRetryJoinBackoffCapsAtMax(t *testing.T) {
+	got := retryJoinBackoff(1*time.Minute, 10)
+	if got != defaultRetryBackoffCap {
+		t.Fatalf("expected backoff to cap at %v, got %v", defaultRetryBackoffCap, got)
+	}
+}
+
+func TestRetryJoinBackoffHandlesShiftOverflow(t *testing.T) {
+	got := retryJoinBackoff(1*time.Second, 100)
+	if got != defaultRetryBackoffCap {
+		t.Fatalf("expected an overflowing shift to fall back to the cap, got %v", got)
+	}
+}
@@ -0,0 +1,214 @@
+package agent
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+)
+
+// delegateVersion is bumped whenever the wire format of jobStateEnvelope
+// changes, so MergeRemoteState can ignore payloads from a node running a
+// different version instead of misinterpreting them during a rolling
+// upgrade.
+const delegateVersion = 1
+
+// maxJobStateBytes keeps a single push/pull payload within memberlist's
+// size limits. A node with more job state than fits in one payload has it
+// split across chunks, one of which is sent per sync round.
+const maxJobStateBytes = 512
+
+// jobStateSummary is the small per-job state snapshot piggybacked on
+// memberlist push/pull sync, giving the cluster a near-real-time view of
+// every job without polling each node over RPC.
+type jobStateSummary struct {
+	Running   bool  `json:"running"`
+	ExitCode  int   `json:"exit_code"`
+	Heartbeat int64 `json:"heartbeat"`
+}
+
+// jobStateEnvelope is the payload exchanged over LocalState/
+// MergeRemoteState. A node's job state may not fit in a single payload, so
+// it's split across Total chunks, each carrying a disjoint subset of Jobs;
+// Chunk identifies which one this payload is. Chunks merge independently
+// as they arrive, so the aggregated view converges over a few push/pull
+// rounds instead of requiring every chunk to land before anything is
+// usable.
+type jobStateEnvelope struct {
+	Version int                        `json:"version"`
+	Node    string                     `json:"node"`
+	Chunk   int                        `json:"chunk"`
+	Total   int                        `json:"total"`
+	Jobs    map[string]jobStateSummary `json:"jobs"`
+}
+
+// delegate implements memberlist.Delegate, gossiping this node's current
+// per-job state and maintaining the cluster-wide view merged from peers.
+type delegate struct {
+	nodeName string
+
+	mu      sync.RWMutex
+	local   map[string]jobStateSummary            // this node's jobs, keyed by job name
+	sendIdx int                                   // which chunk of local state to send next
+	states  map[string]map[string]jobStateSummary // node -> job -> summary
+}
+
+func newDelegate(nodeName string) *delegate {
+	return &delegate{
+		nodeName: nodeName,
+		local:    make(map[string]jobStateSummary),
+		states:   make(map[string]map[string]jobStateSummary),
+	}
+}
+
+// setLocalJobState records one job's latest state so it's included in a
+// future LocalState push/pull round. Keying by job name means a node
+// running several jobs gossips all of them, rather than only the most
+// recently touched one.
+func (d *delegate) setLocalJobState(job string, running bool, exitCode int, heartbeat int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.local[job] = jobStateSummary{
+		Running:   running,
+		ExitCode:  exitCode,
+		Heartbeat: heartbeat,
+	}
+}
+
+// NodeMeta implements memberlist.Delegate. Job state travels over
+// LocalState/MergeRemoteState instead, so node metadata is unused.
+func (d *delegate) NodeMeta(limit int) []byte {
+	return nil
+}
+
+// NotifyMsg implements memberlist.Delegate. Job state doesn't use
+// user messages, only push/pull sync.
+func (d *delegate) NotifyMsg([]byte) {}
+
+// GetBroadcasts implements memberlist.Delegate. Job state is carried by
+// push/pull sync rather than gossip broadcasts.
+func (d *delegate) GetBroadcasts(overhead, limit int) [][]byte {
+	return nil
+}
+
+// LocalState implements memberlist.Delegate, returning one chunk of this
+// node's current job state for push/pull sync. Successive calls rotate
+// through the chunks, so a node with more job state than fits in a single
+// payload still converges cluster-wide within a few rounds instead of
+// ever producing a payload memberlist can't marshal whole.
+func (d *delegate) LocalState(join bool) []byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	names := make([]string, 0, len(d.local))
+	for name := range d.local {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	chunks := d.chunkJobsLocked(names)
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	idx := d.sendIdx % len(chunks)
+	d.sendIdx++
+
+	envelope := jobStateEnvelope{
+		Version: delegateVersion,
+		Node:    d.nodeName,
+		Chunk:   idx,
+		Total:   len(chunks),
+		Jobs:    make(map[string]jobStateSummary, len(chunks[idx])),
+	}
+	for _, name := range chunks[idx] {
+		envelope.Jobs[name] = d.local[name]
+	}
+
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// chunkJobsLocked greedily groups job names so each group's marshalled
+// envelope stays within maxJobStateBytes. Callers must hold d.mu.
+func (d *delegate) chunkJobsLocked(names []string) [][]string {
+	var chunks [][]string
+	var current []string
+
+	for _, name := range names {
+		candidate := append(append([]string{}, current...), name)
+		if len(current) > 0 && d.envelopeSizeLocked(candidate) > maxJobStateBytes {
+			chunks = append(chunks, current)
+			current = []string{name}
+			continue
+		}
+		current = candidate
+	}
+	if len(current) > 0 {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
+
+func (d *delegate) envelopeSizeLocked(names []string) int {
+	jobs := make(map[string]jobStateSummary, len(names))
+	for _, name := range names {
+		jobs[name] = d.local[name]
+	}
+	data, err := json.Marshal(jobStateEnvelope{
+		Node: d.nodeName,
+		Jobs: jobs,
+	})
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
+
+// MergeRemoteState implements memberlist.Delegate, merging one chunk of a
+// peer's job state, received during push/pull sync, into the aggregated
+// cluster view. Chunks are merged independently as they arrive rather
+// than buffered until a full set lands, so the view converges
+// progressively instead of requiring a reassembly barrier.
+func (d *delegate) MergeRemoteState(buf []byte, join bool) {
+	var envelope jobStateEnvelope
+	if err := json.Unmarshal(buf, &envelope); err != nil {
+		return
+	}
+	if envelope.Version != delegateVersion {
+		// A peer running a different delegate version; skip it for now,
+		// it will merge cleanly once both sides are upgraded.
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	node, ok := d.states[envelope.Node]
+	if !ok {
+		node = make(map[string]jobStateSummary)
+		d.states[envelope.Node] = node
+	}
+	for name, summary := range envelope.Jobs {
+		node[name] = summary
+	}
+}
+
+// jobStates returns a snapshot of the aggregated cluster-wide job states,
+// keyed by node name and then job name.
+func (d *delegate) jobStates() map[string]map[string]jobStateSummary {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	out := make(map[string]map[string]jobStateSummary, len(d.states))
+	for node, jobs := range d.states {
+		jobsCopy := make(map[string]jobStateSummary, len(jobs))
+		for name, summary := range jobs {
+			jobsCopy[name] = summary
+		}
+		out[node] = jobsCopy
+	}
+	return out
+}
@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestDelegateLocalStateChunksLargeJobSet(t *testing.T) {
+	d := newDelegate("node1")
+	for i := 0; i < 50; i++ {
+		d.setLocalJobState(fmt.Sprintf("job-%02d", i), true, 0, int64(i))
+	}
+
+	seen := make(map[string]bool)
+	rounds := 0
+	for len(seen) < 50 && rounds < 200 {
+		buf := d.LocalState(false)
+		if buf == nil {
+			t.Fatalf("LocalState returned nil before all jobs were sent")
+		}
+		if len(buf) > maxJobStateBytes {
+			t.Fatalf("LocalState payload of %d bytes exceeds maxJobStateBytes", len(buf))
+		}
+
+		var envelope jobStateEnvelope
+		if err := json.Unmarshal(buf, &envelope); err != nil {
+			t.Fatalf("LocalState produced invalid JSON: %v", err)
+		}
+		for name := range envelope.Jobs {
+			seen[name] = true
+		}
+		rounds++
+	}
+
+	if len(seen) != 50 {
+		t.Fatalf("expected all 50 jobs to be sent across chunks, got %d after %d rounds", len(seen), rounds)
+	}
+}
+
+func TestDelegateMergeRemoteStateKeepsAllJobsPerNode(t *testing.T) {
+	d := newDelegate("node2")
+
+	chunk1, _ := json.Marshal(jobStateEnvelope{
+		Version: delegateVersion,
+		Node:    "node1",
+		Chunk:   0,
+		Total:   2,
+		Jobs:    map[string]jobStateSummary{"job-a": {Running: true, Heartbeat: 1}},
+	})
+	chunk2, _ := json.Marshal(jobStateEnvelope{
+		Version: delegateVersion,
+		Node:    "node1",
+		Chunk:   1,
+		Total:   2,
+		Jobs:    map[string]jobStateSummary{"job-b": {Running: false, ExitCode: 1, Heartbeat: 2}},
+	})
+
+	d.MergeRemoteState(chunk1, false)
+	d.MergeRemoteState(chunk2, false)
+
+	states := d.jobStates()
+	node1, ok := states["node1"]
+	if !ok {
+		t.Fatalf("expected node1 to be present in aggregated states")
+	}
+	if len(node1) != 2 {
+		t.Fatalf("expected 2 jobs for node1, got %d: %+v", len(node1), node1)
+	}
+	if !node1["job-a"].Running {
+		t.Errorf("expected job-a to be running")
+	}
+	if node1["job-b"].Running || node1["job-b"].ExitCode != 1 {
+		t.Errorf("expected job-b stopped with exit code 1, got %+v", node1["job-b"])
+	}
+}
+
+func TestDelegateMergeRemoteStateSkipsVersionMismatch(t *testing.T) {
+	d := newDelegate("node2")
+
+	buf, _ := json.Marshal(jobStateEnvelope{
+		Version: delegateVersion + 1,
+		Node:    "node1",
+		Total:   1,
+		Jobs:    map[string]jobStateSummary{"job-a": {Running: true}},
+	})
+	d.MergeRemoteState(buf, false)
+
+	if len(d.jobStates()) != 0 {
+		t.Fatalf("expected payload from a mismatched version to be ignored")
+	}
+}
+
+func TestDelegateMergeRemoteStateIgnoresGarbage(t *testing.T) {
+	d := newDelegate("node2")
+	d.MergeRemoteState([]byte("not json"), false)
+
+	if len(d.jobStates()) != 0 {
+		t.Fatalf("expected garbage payload to be ignored")
+	}
+}
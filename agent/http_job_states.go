@@ -0,0 +1,22 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ngaut/log"
+)
+
+// registerJobStatesHTTP mounts the /v1/job-states endpoint, which dumps
+// the cluster-wide job-state view JobStates() aggregates from gossip.
+// Call it once, alongside ServeHTTP, during agent startup.
+func registerJobStatesHTTP(a *Agent) {
+	http.HandleFunc("/v1/job-states", a.handleJobStates)
+}
+
+func (a *Agent) handleJobStates(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(a.JobStates()); err != nil {
+		log.Errorf("agent: job-states: failed to encode response: %v", err)
+	}
+}
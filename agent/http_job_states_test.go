@@ -0,0 +1,21 @@
+package agent
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleJobStatesReturnsJSONWithoutDelegate(t *testing.T) {
+	a := &Agent{}
+	req := httptest.NewRequest("GET", "/v1/job-states", nil)
+	w := httptest.NewRecorder()
+
+	a.handleJobStates(w, req)
+
+	if w.Code != 200 {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected JSON content type, got %q", ct)
+	}
+}
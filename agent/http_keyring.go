@@ -0,0 +1,64 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ngaut/log"
+)
+
+// registerKeyringHTTP mounts the install-key/use-key/remove-key/list-keys
+// endpoints. Call it once, alongside ServeHTTP, during agent startup.
+func registerKeyringHTTP(a *Agent) {
+	http.HandleFunc("/v1/keyring/install", a.handleInstallKey)
+	http.HandleFunc("/v1/keyring/use", a.handleUseKey)
+	http.HandleFunc("/v1/keyring/remove", a.handleRemoveKey)
+	http.HandleFunc("/v1/keyring/list", a.handleListKeys)
+}
+
+// keyringHTTPRequest is the POST body for install/use/remove; list takes
+// no body.
+type keyringHTTPRequest struct {
+	Key string `json:"key"`
+}
+
+func (a *Agent) handleInstallKey(w http.ResponseWriter, r *http.Request) {
+	a.serveKeyringOp(w, r, a.InstallKey)
+}
+
+func (a *Agent) handleUseKey(w http.ResponseWriter, r *http.Request) {
+	a.serveKeyringOp(w, r, a.UseKey)
+}
+
+func (a *Agent) handleRemoveKey(w http.ResponseWriter, r *http.Request) {
+	a.serveKeyringOp(w, r, a.RemoveKey)
+}
+
+func (a *Agent) handleListKeys(w http.ResponseWriter, r *http.Request) {
+	resp, err := a.ListKeys()
+	a.writeKeyringResponse(w, resp, err)
+}
+
+// serveKeyringOp decodes a keyringHTTPRequest and runs it through op,
+// shared by install/use/remove since they only differ in which
+// Agent method they call.
+func (a *Agent) serveKeyringOp(w http.ResponseWriter, r *http.Request, op func(string) (*KeyResponse, error)) {
+	var req keyringHTTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp, err := op(req.Key)
+	a.writeKeyringResponse(w, resp, err)
+}
+
+func (a *Agent) writeKeyringResponse(w http.ResponseWriter, resp *KeyResponse, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Errorf("agent: keyring: failed to encode response: %v", err)
+	}
+}
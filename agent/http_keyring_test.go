@@ -0,0 +1,59 @@
+package agent
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeKeyringOpRejectsMalformedBody(t *testing.T) {
+	a := &Agent{}
+	req := httptest.NewRequest("POST", "/v1/keyring/install", strings.NewReader("not json"))
+	w := httptest.NewRecorder()
+
+	a.serveKeyringOp(w, req, func(string) (*KeyResponse, error) {
+		t.Fatal("op should not be called for a malformed body")
+		return nil, nil
+	})
+
+	if w.Code != 400 {
+		t.Fatalf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestServeKeyringOpReturnsOpResult(t *testing.T) {
+	a := &Agent{}
+	req := httptest.NewRequest("POST", "/v1/keyring/install", strings.NewReader(`{"key":"abc"}`))
+	w := httptest.NewRecorder()
+
+	var gotKey string
+	a.serveKeyringOp(w, req, func(key string) (*KeyResponse, error) {
+		gotKey = key
+		return &KeyResponse{NumNodes: 3}, nil
+	})
+
+	if gotKey != "abc" {
+		t.Fatalf("expected op to receive key %q, got %q", "abc", gotKey)
+	}
+	if w.Code != 200 {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `"NumNodes":3`) {
+		t.Fatalf("expected response body to contain the op's result, got %q", w.Body.String())
+	}
+}
+
+func TestServeKeyringOpPropagatesOpError(t *testing.T) {
+	a := &Agent{}
+	req := httptest.NewRequest("POST", "/v1/keyring/install", strings.NewReader(`{"key":"abc"}`))
+	w := httptest.NewRecorder()
+
+	a.serveKeyringOp(w, req, func(string) (*KeyResponse, error) {
+		return nil, errors.New("boom")
+	})
+
+	if w.Code != 500 {
+		t.Fatalf("expected status 500, got %d", w.Code)
+	}
+}
@@ -0,0 +1,22 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ngaut/log"
+)
+
+// registerMetricsHTTP mounts the /debug/metrics endpoint, which dumps the
+// in-memory metrics snapshot Metrics() returns. Call it once, alongside
+// ServeHTTP, during agent startup.
+func registerMetricsHTTP(a *Agent) {
+	http.HandleFunc("/debug/metrics", a.handleMetrics)
+}
+
+func (a *Agent) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(a.Metrics()); err != nil {
+		log.Errorf("agent: metrics: failed to encode response: %v", err)
+	}
+}
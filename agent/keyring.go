@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/serf/serf"
+)
+
+// KeyResponse mirrors serf.KeyResponse, returning the per-member outcome of
+// a key management operation so RPC/HTTP callers can tell which nodes
+// failed to apply it.
+type KeyResponse struct {
+	Messages map[string]string
+	Keys     map[string]int
+	NumNodes int
+}
+
+// decodeEncryptKey base64-decodes a gossip encryption key and validates
+// that it is an acceptable AES key size.
+func decodeEncryptKey(key string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid base64 encoding: %v", err)
+	}
+	switch len(raw) {
+	case 16, 24, 32:
+		return raw, nil
+	default:
+		return nil, fmt.Errorf("Key size must be 16, 24 or 32 bytes")
+	}
+}
+
+// validateKeyringFile makes sure a configured keyring file, if it already
+// exists, contains base64 keys of a valid size so the agent refuses to
+// start rather than join the cluster with a broken keyring.
+func validateKeyringFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var keys []string
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return fmt.Errorf("Failed to parse keyring file: %v", err)
+	}
+	for _, key := range keys {
+		if _, err := decodeEncryptKey(key); err != nil {
+			return fmt.Errorf("Keyring file contains an invalid key: %v", err)
+		}
+	}
+	return nil
+}
+
+// InstallKey installs a new gossip encryption key on every reachable
+// member of the cluster without promoting it to primary use, the first
+// step of an online key rotation.
+func (a *Agent) InstallKey(key string) (*KeyResponse, error) {
+	if _, err := decodeEncryptKey(key); err != nil {
+		return nil, err
+	}
+	resp, err := a.serf.KeyManager().InstallKey(key)
+	return newKeyResponse(resp), err
+}
+
+// UseKey promotes an already-installed key to primary, so all new
+// outbound gossip is encrypted with it.
+func (a *Agent) UseKey(key string) (*KeyResponse, error) {
+	resp, err := a.serf.KeyManager().UseKey(key)
+	return newKeyResponse(resp), err
+}
+
+// RemoveKey retires a key from the cluster. The current primary key can
+// not be removed until another key has been promoted with UseKey.
+func (a *Agent) RemoveKey(key string) (*KeyResponse, error) {
+	resp, err := a.serf.KeyManager().RemoveKey(key)
+	return newKeyResponse(resp), err
+}
+
+// ListKeys returns every gossip encryption key currently installed across
+// the cluster, along with a count of members using each one.
+func (a *Agent) ListKeys() (*KeyResponse, error) {
+	resp, err := a.serf.KeyManager().ListKeys()
+	return newKeyResponse(resp), err
+}
+
+func newKeyResponse(resp *serf.KeyResponse) *KeyResponse {
+	if resp == nil {
+		return nil
+	}
+	return &KeyResponse{
+		Messages: resp.Messages,
+		Keys:     resp.Keys,
+		NumNodes: resp.NumNodes,
+	}
+}
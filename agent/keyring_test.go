@@ -0,0 +1,88 @@
+package agent
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func b64Key(n int) string {
+	return base64.StdEncoding.EncodeToString(make([]byte, n))
+}
+
+func TestDecodeEncryptKeyAcceptsValidAESSizes(t *testing.T) {
+	for _, n := range []int{16, 24, 32} {
+		if _, err := decodeEncryptKey(b64Key(n)); err != nil {
+			t.Errorf("decodeEncryptKey(%d-byte key): unexpected error: %v", n, err)
+		}
+	}
+}
+
+func TestDecodeEncryptKeyRejectsBadSize(t *testing.T) {
+	if _, err := decodeEncryptKey(b64Key(10)); err == nil {
+		t.Fatal("expected an error for a 10-byte key, got nil")
+	}
+}
+
+func TestDecodeEncryptKeyRejectsInvalidBase64(t *testing.T) {
+	if _, err := decodeEncryptKey("not-valid-base64!!"); err == nil {
+		t.Fatal("expected an error for invalid base64, got nil")
+	}
+}
+
+func TestValidateKeyringFileMissingIsOK(t *testing.T) {
+	if err := validateKeyringFile(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Fatalf("expected a missing keyring file to be OK, got: %v", err)
+	}
+}
+
+func TestValidateKeyringFileAllValid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	writeKeyringFile(t, path, `["`+b64Key(16)+`","`+b64Key(32)+`"]`)
+
+	if err := validateKeyringFile(path); err != nil {
+		t.Fatalf("expected an all-valid keyring file to be OK, got: %v", err)
+	}
+}
+
+func TestValidateKeyringFileMalformedJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	writeKeyringFile(t, path, `not json`)
+
+	if err := validateKeyringFile(path); err == nil {
+		t.Fatal("expected an error for malformed JSON, got nil")
+	}
+}
+
+// TestValidateKeyringFileMixedEncryptedUnencrypted covers a cluster
+// misconfiguration where an operator has appended a correctly-sized key
+// for an encrypted member alongside a leftover or hand-edited entry that
+// isn't a valid AES key (e.g. a plaintext placeholder from a member that
+// never had encryption enabled). The file as a whole must be rejected
+// rather than partially accepted.
+func TestValidateKeyringFileMixedEncryptedUnencrypted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	writeKeyringFile(t, path, `["`+b64Key(32)+`","not-encrypted"]`)
+
+	err := validateKeyringFile(path)
+	if err == nil {
+		t.Fatal("expected a mixed valid/invalid keyring file to be rejected, got nil")
+	}
+}
+
+func TestValidateKeyringFileEmptyListIsUnencryptedAndOK(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	writeKeyringFile(t, path, `[]`)
+
+	if err := validateKeyringFile(path); err != nil {
+		t.Fatalf("expected an empty keyring (no encryption) to be OK, got: %v", err)
+	}
+}
+
+func writeKeyringFile(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
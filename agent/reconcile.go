@@ -0,0 +1,185 @@
+package agent
+
+import (
+	"encoding/json"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/ngaut/log"
+
+	"udup/telemetry"
+	"udup/wal"
+)
+
+const (
+	reconcileBaseInterval = 5 * time.Second
+)
+
+// reconcile runs on server agents for as long as they are the elected
+// leader, periodically diffing the jobs recorded in the store against the
+// scheduler's active set and re-issuing any QueryRunJob/QueryStopJob that
+// appear to have been lost. This closes the window where a gossip query
+// never reaches its target and the job silently never (or always) runs.
+//
+// It wakes on three things: a jittered ticker scaled by cluster size (so
+// servers don't all reconcile in lockstep after a leader change), writes
+// to a.triggerCh for immediate reconciliation after a store mutation, and
+// leadership changes on a.leaderCh, which pause reconciliation until this
+// server is elected again.
+func (a *Agent) reconcile() {
+	leader := false
+	ticker := time.NewTicker(a.reconcileInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case isLeader := <-a.leaderCh:
+			leader = isLeader
+			ticker.Stop()
+			ticker = time.NewTicker(a.reconcileInterval())
+			if leader {
+				a.reconcileOnce()
+			}
+
+		case <-ticker.C:
+			if leader {
+				a.reconcileOnce()
+			}
+
+		case <-a.triggerCh:
+			if leader {
+				a.reconcileOnce()
+			}
+
+		case <-a.shutdownCh:
+			return
+		}
+	}
+}
+
+// reconcileInterval scales the base reconcile interval by the number of
+// known servers and adds a random stagger, so a leader change doesn't
+// cause every server to reconcile at the same instant.
+func (a *Agent) reconcileInterval() time.Duration {
+	n := len(a.listServers())
+	if n == 0 {
+		n = 1
+	}
+	jitter := time.Duration(rand.Int63n(int64(reconcileBaseInterval)))
+	return time.Duration(n)*reconcileBaseInterval + jitter
+}
+
+// notifyLeaderChange reports a leadership transition to the reconcile
+// loop so it can pause while this server isn't the leader and resume
+// immediately once it's re-elected. It also updates the atomic flag
+// isLeader reads, so other parts of the agent (e.g. WAL replay) can check
+// leadership without going through the reconcile loop's channel.
+func (a *Agent) notifyLeaderChange(leader bool) {
+	if leader {
+		atomic.StoreInt32(&a.leading, 1)
+	} else {
+		atomic.StoreInt32(&a.leading, 0)
+	}
+
+	select {
+	case a.leaderCh <- leader:
+	default:
+	}
+}
+
+// isLeader reports whether this server currently holds cluster
+// leadership.
+func (a *Agent) isLeader() bool {
+	return atomic.LoadInt32(&a.leading) == 1
+}
+
+// TriggerReconcile requests an immediate reconciliation pass, without
+// waiting for the next ticker tick. Callers mutating the store (job
+// create/update/delete) should call this so drift is corrected right away
+// instead of waiting out the jittered interval.
+func (a *Agent) TriggerReconcile() {
+	select {
+	case a.triggerCh <- struct{}{}:
+	default:
+	}
+}
+
+// reconcileOnce lists the jobs recorded in the store, compares them
+// against the jobs the scheduler believes are active, and re-dispatches
+// RunJob/StopJob queries for any job that has drifted.
+func (a *Agent) reconcileOnce() {
+	getJobsStart := time.Now()
+	jobs, err := a.store.GetJobs()
+	telemetry.MeasureSince([]string{"store", "get_jobs"}, getJobsStart)
+	if err != nil {
+		log.Errorf("agent: reconcile: failed to list jobs: %v", err)
+		return
+	}
+
+	wantRunning := make(map[string]*Job, len(jobs))
+	for _, job := range jobs {
+		if job.Disabled {
+			continue
+		}
+		wantRunning[job.Name] = job
+	}
+
+	toRun, toStop := diffJobs(wantRunning, a.sched.Jobs())
+
+	for _, job := range toRun {
+		log.Warnf("agent: reconcile: job %q should be running but isn't, re-dispatching", job.Name)
+		if err := a.dispatchRunJob(job); err != nil {
+			log.Errorf("agent: reconcile: failed to re-dispatch job %q: %v", job.Name, err)
+		}
+	}
+	for _, job := range toStop {
+		log.Warnf("agent: reconcile: job %q should not be running, stopping", job.Name)
+		if err := a.dispatchStopJob(job); err != nil {
+			log.Errorf("agent: reconcile: failed to stop job %q: %v", job.Name, err)
+		}
+	}
+}
+
+// diffJobs compares the jobs the store says should be running against the
+// jobs the scheduler believes are active, and reports which need a RunJob
+// re-dispatched and which need a StopJob. It's a pure function so the
+// drift-detection logic can be unit tested without a real store/scheduler.
+func diffJobs(wantRunning, active map[string]*Job) (toRun, toStop []*Job) {
+	for name, job := range wantRunning {
+		if _, ok := active[name]; !ok {
+			toRun = append(toRun, job)
+		}
+	}
+	for name, job := range active {
+		if _, ok := wantRunning[name]; !ok {
+			toStop = append(toStop, job)
+		}
+	}
+	return toRun, toStop
+}
+
+// dispatchRunJob issues a QueryRunJob Serf query to re-assert that a job
+// should be running, the same request path as the original scheduling
+// decision.
+func (a *Agent) dispatchRunJob(job *Job) error {
+	payload, err := json.Marshal(&RunQueryParam{Job: job})
+	if err != nil {
+		return err
+	}
+	a.trackDispatch(wal.EntryRunJob, job)
+	_, err = a.serf.Query(QueryRunJob, payload, a.serf.DefaultQueryParams())
+	return err
+}
+
+// dispatchStopJob issues a QueryStopJob Serf query to re-assert that a job
+// should be stopped.
+func (a *Agent) dispatchStopJob(job *Job) error {
+	payload, err := json.Marshal(&RunQueryParam{Job: job})
+	if err != nil {
+		return err
+	}
+	a.trackDispatch(wal.EntryStopJob, job)
+	_, err = a.serf.Query(QueryStopJob, payload, a.serf.DefaultQueryParams())
+	return err
+}
@@ -0,0 +1,40 @@
+package agent
+
+import "testing"
+
+func TestDiffJobsFindsMissingAndExtra(t *testing.T) {
+	wantRunning := map[string]*Job{
+		"a": {Name: "a"},
+		"b": {Name: "b"},
+	}
+	active := map[string]*Job{
+		"b": {Name: "b"},
+		"c": {Name: "c"},
+	}
+
+	toRun, toStop := diffJobs(wantRunning, active)
+
+	if len(toRun) != 1 || toRun[0].Name != "a" {
+		t.Fatalf("expected only job %q to need running, got %+v", "a", toRun)
+	}
+	if len(toStop) != 1 || toStop[0].Name != "c" {
+		t.Fatalf("expected only job %q to need stopping, got %+v", "c", toStop)
+	}
+}
+
+func TestDiffJobsInSyncProducesNoDrift(t *testing.T) {
+	jobs := map[string]*Job{"a": {Name: "a"}}
+
+	toRun, toStop := diffJobs(jobs, jobs)
+
+	if len(toRun) != 0 || len(toStop) != 0 {
+		t.Fatalf("expected no drift when want/active match, got toRun=%+v toStop=%+v", toRun, toStop)
+	}
+}
+
+func TestDiffJobsEmptyInputs(t *testing.T) {
+	toRun, toStop := diffJobs(nil, nil)
+	if len(toRun) != 0 || len(toStop) != 0 {
+		t.Fatalf("expected no drift for empty inputs, got toRun=%+v toStop=%+v", toRun, toStop)
+	}
+}
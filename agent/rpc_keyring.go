@@ -0,0 +1,63 @@
+package agent
+
+import "net/rpc"
+
+// KeyringRPCRequest is the argument type for the install-key/use-key/
+// remove-key/list-keys RPC endpoints.
+type KeyringRPCRequest struct {
+	Key string
+}
+
+// KeyringRPC exposes the agent's gossip key rotation operations as
+// net/rpc endpoints (KeyringRPC.Install, KeyringRPC.Use,
+// KeyringRPC.Remove, KeyringRPC.List), registered against the same
+// net/rpc server listenRPC serves the job RPCs from.
+type KeyringRPC struct {
+	agent *Agent
+}
+
+// registerKeyringRPC registers the keyring RPC endpoints. Call it once,
+// alongside listenRPC, during agent startup.
+func registerKeyringRPC(a *Agent) error {
+	return rpc.Register(&KeyringRPC{agent: a})
+}
+
+// Install installs a new gossip encryption key across the cluster.
+func (k *KeyringRPC) Install(args *KeyringRPCRequest, resp *KeyResponse) error {
+	r, err := k.agent.InstallKey(args.Key)
+	if err != nil {
+		return err
+	}
+	*resp = *r
+	return nil
+}
+
+// Use promotes an installed key to primary.
+func (k *KeyringRPC) Use(args *KeyringRPCRequest, resp *KeyResponse) error {
+	r, err := k.agent.UseKey(args.Key)
+	if err != nil {
+		return err
+	}
+	*resp = *r
+	return nil
+}
+
+// Remove retires a key from the cluster.
+func (k *KeyringRPC) Remove(args *KeyringRPCRequest, resp *KeyResponse) error {
+	r, err := k.agent.RemoveKey(args.Key)
+	if err != nil {
+		return err
+	}
+	*resp = *r
+	return nil
+}
+
+// List returns every key currently installed across the cluster.
+func (k *KeyringRPC) List(args *KeyringRPCRequest, resp *KeyResponse) error {
+	r, err := k.agent.ListKeys()
+	if err != nil {
+		return err
+	}
+	*resp = *r
+	return nil
+}
@@ -0,0 +1,174 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ngaut/log"
+
+	uconf "udup/config"
+)
+
+const (
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultUnhealthyWindow     = 30 * time.Second
+	defaultMaxRestarts         = 5
+	defaultRestartBackoff      = 5 * time.Second
+	defaultRestartBackoffCap   = 2 * time.Minute
+)
+
+// Supervisor runs an Agent as a managed process, restarting it when health
+// checks fail for longer than an allowed window. Restarts are bounded by
+// exponential backoff and a maximum restart budget; once that budget is
+// exhausted, Run returns an error so the caller can exit with a non-zero
+// status instead of spinning forever.
+type Supervisor struct {
+	config *uconf.Config
+
+	mu       sync.Mutex
+	agent    *Agent
+	restarts int
+	lastOK   time.Time
+
+	stopCh chan struct{}
+}
+
+// NewSupervisor creates a Supervisor for the given configuration. Call Run
+// to start and monitor the agent.
+func NewSupervisor(config *uconf.Config) *Supervisor {
+	return &Supervisor{
+		config: config,
+		stopCh: make(chan struct{}),
+	}
+}
+
+// Run starts the agent and monitors it for the life of the process,
+// restarting it on sustained health-check failure. It returns nil if Stop
+// is called, and an error once the restart budget has been exhausted.
+func (s *Supervisor) Run() error {
+	if err := s.start(); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(defaultHealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if s.healthy() {
+				s.mu.Lock()
+				s.lastOK = time.Now()
+				s.restarts = 0
+				s.mu.Unlock()
+				continue
+			}
+
+			s.mu.Lock()
+			unhealthyFor := time.Since(s.lastOK)
+			s.mu.Unlock()
+			if unhealthyFor < defaultUnhealthyWindow {
+				continue
+			}
+
+			if err := s.restart(); err != nil {
+				return err
+			}
+
+		case <-s.stopCh:
+			s.mu.Lock()
+			a := s.agent
+			s.mu.Unlock()
+			if a != nil {
+				return a.Shutdown()
+			}
+			return nil
+		}
+	}
+}
+
+// Stop shuts down the supervised agent and stops monitoring it.
+func (s *Supervisor) Stop() {
+	close(s.stopCh)
+}
+
+func (s *Supervisor) start() error {
+	a, err := NewAgent(s.config)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.agent = a
+	s.lastOK = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// healthy reports whether the supervised agent looks alive: it has serf
+// peers, can reach the store and knows a leader within the leader TTL
+// (servers only), and its event loop heartbeat is recent.
+func (s *Supervisor) healthy() bool {
+	s.mu.Lock()
+	a := s.agent
+	s.mu.Unlock()
+	if a == nil {
+		return false
+	}
+
+	if len(a.serf.Members()) == 0 {
+		return false
+	}
+
+	if a.config.Server {
+		if _, err := a.store.GetJobs(); err != nil {
+			return false
+		}
+		if _, err := a.leaderMember(); err != nil {
+			return false
+		}
+	}
+
+	if time.Since(a.lastHeartbeat()) > defaultLeaderTTL {
+		return false
+	}
+
+	return true
+}
+
+// restart tears down the unhealthy agent and brings up a fresh one, after
+// an exponential backoff. It fails once the restart budget is exceeded.
+func (s *Supervisor) restart() error {
+	s.mu.Lock()
+	s.restarts++
+	restarts := s.restarts
+	a := s.agent
+	s.mu.Unlock()
+
+	if restarts > defaultMaxRestarts {
+		return fmt.Errorf("supervisor: restart budget of %d exceeded, giving up", defaultMaxRestarts)
+	}
+
+	backoff := restartBackoff(restarts)
+	log.Warnf("supervisor: agent unhealthy, restarting in %v (attempt %d/%d)", backoff, restarts, defaultMaxRestarts)
+	time.Sleep(backoff)
+
+	if a != nil {
+		a.Shutdown()
+	}
+	return s.start()
+}
+
+// restartBackoff returns the delay before the nth restart (1-indexed),
+// doubling defaultRestartBackoff each time and capping at
+// defaultRestartBackoffCap. It's a pure function so the backoff curve can
+// be unit tested without standing up a real Supervisor.
+func restartBackoff(restarts int) time.Duration {
+	backoff := time.Duration(math.Pow(2, float64(restarts-1))) * defaultRestartBackoff
+	if backoff > defaultRestartBackoffCap {
+		backoff = defaultRestartBackoffCap
+	}
+	return backoff
+}
@@ -0,0 +1,28 @@
+package agent
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestartBackoffDoublesPerAttempt(t *testing.T) {
+	cases := []struct {
+		restarts int
+		want     time.Duration
+	}{
+		{1, defaultRestartBackoff},
+		{2, 2 * defaultRestartBackoff},
+		{3, 4 * defaultRestartBackoff},
+	}
+	for _, c := range cases {
+		if got := restartBackoff(c.restarts); got != c.want {
+			t.Errorf("restartBackoff(%d) = %v, want %v", c.restarts, got, c.want)
+		}
+	}
+}
+
+func TestRestartBackoffCapsAtMax(t *testing.T) {
+	if got := restartBackoff(defaultMaxRestarts + 1); got != defaultRestartBackoffCap {
+		t.Fatalf("expected backoff to cap at %v, got %v", defaultRestartBackoffCap, got)
+	}
+}
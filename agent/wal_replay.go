@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/ngaut/log"
+
+	"udup/wal"
+)
+
+// walCompactInterval is how often a server rewrites its WAL segment file
+// to reclaim the space held by entries already marked acknowledged by
+// Truncate.
+const walCompactInterval = 5 * time.Minute
+
+// walCompactLoop periodically compacts the WAL in the background, for the
+// life of the agent.
+func (a *Agent) walCompactLoop() {
+	ticker := time.NewTicker(walCompactInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := a.wal.Compact(); err != nil {
+				log.Errorf("agent: wal: compaction failed: %v", err)
+			}
+
+		case <-a.shutdownCh:
+			return
+		}
+	}
+}
+
+// trackDispatch appends an outbound job dispatch query to the WAL before
+// it is sent over gossip, so it can be replayed to the target node if the
+// query is lost during a partition. dispatchRunJob/dispatchStopJob are the
+// only place this package issues QueryRunJob/QueryStopJob, so reconcile's
+// periodic drift correction is also what backfills the WAL for a job's
+// very first dispatch, not just its re-dispatches.
+func (a *Agent) trackDispatch(typ wal.EntryType, job *Job) {
+	if a.wal == nil {
+		return
+	}
+	if _, err := a.wal.Append(job.NodeName, typ, job); err != nil {
+		log.Errorf("agent: wal: failed to append entry for %v: %v", job.NodeName, err)
+	}
+}
+
+// replayWAL re-sends any job dispatch queries target hasn't acknowledged
+// yet, via direct RPC rather than gossip. It runs when a member
+// transitions back to alive after a failure or graceful leave, closing
+// the gap left by a dropped Serf query during the partition.
+func (a *Agent) replayWAL(target string) {
+	if a.wal == nil || a.store == nil {
+		return
+	}
+
+	lastAck, err := a.store.GetLastAck(target)
+	if err != nil {
+		log.Errorf("agent: wal: failed to load last-ack for %v: %v", target, err)
+		return
+	}
+
+	entries, err := a.wal.Entries(target, lastAck)
+	if err != nil {
+		log.Errorf("agent: wal: failed to read entries for %v: %v", target, err)
+		return
+	}
+	if len(entries) == 0 {
+		return
+	}
+
+	rpcServer, err := a.queryRPCConfig(target)
+	if err != nil {
+		log.Errorf("agent: wal: failed to resolve RPC address for %v: %v", target, err)
+		return
+	}
+	rc := &RPCClient{ServerAddr: string(rpcServer)}
+
+	var lastReplayed uint64
+	for _, entry := range entries {
+		var job Job
+		if err := json.Unmarshal(entry.Job, &job); err != nil {
+			log.Errorf("agent: wal: failed to decode entry %d for %v: %v", entry.Seq, target, err)
+			continue
+		}
+
+		var replayErr error
+		switch entry.Type {
+		case wal.EntryRunJob:
+			replayErr = rc.callRunJob(&job)
+		case wal.EntryStopJob:
+			replayErr = rc.callStopJob(&job)
+		}
+		if replayErr != nil {
+			log.Errorf("agent: wal: failed to replay entry %d to %v: %v", entry.Seq, target, replayErr)
+			break
+		}
+
+		if err := a.store.SetLastAck(target, entry.Seq); err != nil {
+			log.Errorf("agent: wal: failed to persist last-ack for %v: %v", target, err)
+			break
+		}
+		lastReplayed = entry.Seq
+	}
+
+	// Mark the replayed range as acknowledged in one call; Compact (run
+	// periodically in the background) is what actually reclaims the
+	// space, so draining a large backlog here doesn't rewrite the segment
+	// file once per entry.
+	if lastReplayed > 0 {
+		if err := a.wal.Truncate(target, lastReplayed); err != nil {
+			log.Errorf("agent: wal: failed to truncate entries for %v: %v", target, err)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package discover
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// awsProvider discovers peers by listing the EC2 instances tagged with a
+// given key/value pair, for clusters bootstrapped inside an autoscaling
+// group where peer IPs aren't known ahead of time.
+type awsProvider struct{}
+
+func init() {
+	Register("aws", &awsProvider{})
+}
+
+func (p *awsProvider) Addrs(args map[string]string) ([]string, error) {
+	region := args["region"]
+	if region == "" {
+		return nil, fmt.Errorf("discover-aws: 'region' is required")
+	}
+	tagKey := args["tag_key"]
+	tagValue := args["tag_value"]
+	if tagKey == "" || tagValue == "" {
+		return nil, fmt.Errorf("discover-aws: 'tag_key' and 'tag_value' are required")
+	}
+
+	var creds *credentials.Credentials
+	if args["access_key_id"] != "" && args["secret_access_key"] != "" {
+		creds = credentials.NewStaticCredentials(args["access_key_id"], args["secret_access_key"], "")
+	}
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String(region),
+		Credentials: creds,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("discover-aws: %v", err)
+	}
+
+	resp, err := ec2.New(sess).DescribeInstances(&ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("tag:" + tagKey),
+				Values: []*string{aws.String(tagValue)},
+			},
+			{
+				Name:   aws.String("instance-state-name"),
+				Values: []*string{aws.String("running")},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("discover-aws: %v", err)
+	}
+
+	var addrs []string
+	for _, res := range resp.Reservations {
+		for _, inst := range res.Instances {
+			if inst.PrivateIpAddress != nil {
+				addrs = append(addrs, *inst.PrivateIpAddress)
+			}
+		}
+	}
+	return addrs, nil
+}
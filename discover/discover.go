@@ -0,0 +1,48 @@
+// Package discover resolves cluster peer addresses dynamically, so an
+// agent's retry_join can bootstrap against a cloud provider's API instead
+// of a static list of IPs that don't survive an autoscaling event.
+package discover
+
+import "strings"
+
+// Provider resolves a set of node addresses from a provider-specific set
+// of arguments, e.g. an EC2 tag filter or a GCE project.
+type Provider interface {
+	Addrs(args map[string]string) ([]string, error)
+}
+
+var providers = map[string]Provider{}
+
+// Register adds a Provider under the given name, so it can be selected
+// from a retry_join directive's "provider=<name>" field. Providers
+// register themselves from an init func.
+func Register(name string, p Provider) {
+	providers[name] = p
+}
+
+// Get looks up a registered Provider by name.
+func Get(name string) (Provider, bool) {
+	p, ok := providers[name]
+	return p, ok
+}
+
+// Parse splits a retry_join directive of the form
+// "provider=aws tag_key=udup tag_value=server region=us-east-1" into a map
+// of its key/value arguments.
+func Parse(s string) map[string]string {
+	args := make(map[string]string)
+	for _, field := range strings.Fields(s) {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		args[kv[0]] = kv[1]
+	}
+	return args
+}
+
+// IsDiscover reports whether a retry_join entry names a provider rather
+// than a static address.
+func IsDiscover(s string) bool {
+	return strings.Contains(s, "provider=")
+}
@@ -0,0 +1,75 @@
+package discover
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSplitsKeyValueFields(t *testing.T) {
+	got := Parse("provider=aws tag_key=udup tag_value=server region=us-east-1")
+	want := map[string]string{
+		"provider":  "aws",
+		"tag_key":   "udup",
+		"tag_value": "server",
+		"region":    "us-east-1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Parse() = %#v, want %#v", got, want)
+	}
+}
+
+func TestParseIgnoresFieldsWithoutEquals(t *testing.T) {
+	got := Parse("provider=aws garbage region=us-east-1")
+	if _, ok := got["garbage"]; ok {
+		t.Fatalf("expected a field without '=' to be skipped, got %#v", got)
+	}
+	if got["region"] != "us-east-1" {
+		t.Fatalf("expected region to still parse, got %#v", got)
+	}
+}
+
+func TestParseEmptyString(t *testing.T) {
+	got := Parse("")
+	if len(got) != 0 {
+		t.Fatalf("expected an empty map for an empty string, got %#v", got)
+	}
+}
+
+func TestIsDiscover(t *testing.T) {
+	cases := []struct {
+		in   string
+		want bool
+	}{
+		{"provider=aws tag_key=udup", true},
+		{"10.0.0.1:4648", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		if got := IsDiscover(c.in); got != c.want {
+			t.Errorf("IsDiscover(%q) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestRegisterAndGet(t *testing.T) {
+	p := &fakeProvider{}
+	Register("discover-test-provider", p)
+
+	got, ok := Get("discover-test-provider")
+	if !ok {
+		t.Fatal("expected Get to find the just-registered provider")
+	}
+	if got != p {
+		t.Fatalf("expected Get to return the registered provider, got %#v", got)
+	}
+
+	if _, ok := Get("nonexistent-provider"); ok {
+		t.Fatal("expected Get to report false for an unregistered name")
+	}
+}
+
+type fakeProvider struct{}
+
+func (f *fakeProvider) Addrs(args map[string]string) ([]string, error) {
+	return nil, nil
+}
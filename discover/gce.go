@@ -0,0 +1,95 @@
+package discover
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/oauth2/google"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// gceProvider discovers peers by listing Compute Engine instances in a
+// project, optionally scoped to a zone pattern and filtered by network
+// tag, for clusters bootstrapped inside a managed instance group.
+type gceProvider struct{}
+
+func init() {
+	Register("gce", &gceProvider{})
+}
+
+func (p *gceProvider) Addrs(args map[string]string) ([]string, error) {
+	project := args["project_name"]
+	if project == "" {
+		return nil, fmt.Errorf("discover-gce: 'project_name' is required")
+	}
+
+	ctx := context.Background()
+	client, err := google.DefaultClient(ctx, compute.ComputeReadonlyScope)
+	if err != nil {
+		return nil, fmt.Errorf("discover-gce: %v", err)
+	}
+	svc, err := compute.New(client)
+	if err != nil {
+		return nil, fmt.Errorf("discover-gce: %v", err)
+	}
+
+	zones, err := listZones(ctx, svc, project, args["zone_pattern"])
+	if err != nil {
+		return nil, fmt.Errorf("discover-gce: %v", err)
+	}
+
+	tagValue := args["tag_value"]
+	var addrs []string
+	for _, zone := range zones {
+		err := svc.Instances.List(project, zone).Pages(ctx, func(page *compute.InstanceList) error {
+			for _, inst := range page.Items {
+				if tagValue != "" && !hasTag(inst.Tags, tagValue) {
+					continue
+				}
+				for _, iface := range inst.NetworkInterfaces {
+					if iface.NetworkIP != "" {
+						addrs = append(addrs, iface.NetworkIP)
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("discover-gce: %v", err)
+		}
+	}
+	return addrs, nil
+}
+
+func hasTag(tags *compute.Tags, value string) bool {
+	if tags == nil {
+		return false
+	}
+	for _, t := range tags.Items {
+		if t == value {
+			return true
+		}
+	}
+	return false
+}
+
+// listZones resolves a zone_pattern argument to a concrete list of zone
+// names, expanding it against the project's zones when it contains a "*".
+func listZones(ctx context.Context, svc *compute.Service, project, pattern string) ([]string, error) {
+	if pattern != "" && !strings.Contains(pattern, "*") {
+		return []string{pattern}, nil
+	}
+
+	var zones []string
+	match := strings.Trim(pattern, "*")
+	err := svc.Zones.List(project).Pages(ctx, func(page *compute.ZoneList) error {
+		for _, z := range page.Items {
+			if pattern == "" || strings.Contains(z.Name, match) {
+				zones = append(zones, z.Name)
+			}
+		}
+		return nil
+	})
+	return zones, err
+}
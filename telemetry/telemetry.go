@@ -0,0 +1,107 @@
+// Package telemetry wires the agent's scheduler and event loop into
+// armon/go-metrics, so operators can pick a sink (statsd, datadog,
+// circonus, or an in-memory buffer dumped over HTTP) at boot. Every
+// exported helper is a no-op until Init has configured a sink, so callers
+// never need to guard emission on whether telemetry is enabled.
+package telemetry
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/armon/go-metrics/circonus"
+	"github.com/armon/go-metrics/datadog"
+	"github.com/armon/go-metrics/prometheus"
+	"github.com/ngaut/log"
+
+	uconf "udup/config"
+)
+
+var (
+	enabled   bool
+	inmemSink *metrics.InmemSink
+)
+
+// Init configures the global go-metrics sink selected by the agent's
+// telemetry configuration. Call it once during agent startup; telemetry
+// stays disabled when the configuration is nil or not enabled. An enabled
+// configuration with no external sink set uses the in-memory sink.
+func Init(config *uconf.TelemetryConfig) error {
+	if config == nil || !config.Enabled {
+		return nil
+	}
+
+	metricsConf := metrics.DefaultConfig("udup")
+	metricsConf.EnableHostname = !config.DisableHostname
+
+	sink, err := newSink(config, metricsConf)
+	if err != nil {
+		return fmt.Errorf("telemetry: failed to set up sink: %v", err)
+	}
+
+	if _, err := metrics.NewGlobal(metricsConf, sink); err != nil {
+		return fmt.Errorf("telemetry: failed to start: %v", err)
+	}
+
+	enabled = true
+	log.Info("telemetry: metrics enabled")
+	return nil
+}
+
+func newSink(config *uconf.TelemetryConfig, metricsConf *metrics.Config) (metrics.MetricSink, error) {
+	switch {
+	case config.StatsdAddr != "":
+		return metrics.NewStatsdSink(config.StatsdAddr)
+	case config.StatsiteAddr != "":
+		return metrics.NewStatsiteSink(config.StatsiteAddr)
+	case config.DogStatsdAddr != "":
+		return datadog.NewDogStatsdSink(config.DogStatsdAddr, metricsConf.HostName)
+	case config.CirconusAPIToken != "" || config.CirconusSubmissionURL != "":
+		cfg := &circonus.Config{}
+		cfg.CheckManager.API.TokenKey = config.CirconusAPIToken
+		cfg.CheckManager.Check.SubmissionURL = config.CirconusSubmissionURL
+		return circonus.NewCirconusSink(cfg)
+	case config.EnablePrometheus:
+		return prometheus.NewPrometheusSink()
+	default:
+		inmemSink = metrics.NewInmemSink(10*time.Second, time.Minute)
+		return inmemSink, nil
+	}
+}
+
+// DisplayMetrics returns the latest in-memory metrics snapshot for a
+// /debug/metrics HTTP endpoint. It is only populated when no external sink
+// was configured, since external sinks own their own retention.
+func DisplayMetrics() map[string]interface{} {
+	if inmemSink == nil {
+		return nil
+	}
+	data := inmemSink.Data()
+	out := make(map[string]interface{}, len(data))
+	for _, interval := range data {
+		out[interval.Interval.String()] = interval
+	}
+	return out
+}
+
+// IncrCounter increments a counter metric by the given value.
+func IncrCounter(key []string, val float32) {
+	if enabled {
+		metrics.IncrCounter(key, val)
+	}
+}
+
+// SetGauge sets a gauge metric to the given value.
+func SetGauge(key []string, val float32) {
+	if enabled {
+		metrics.SetGauge(key, val)
+	}
+}
+
+// MeasureSince records the elapsed time since start as a timer sample.
+func MeasureSince(key []string, start time.Time) {
+	if enabled {
+		metrics.MeasureSince(key, start)
+	}
+}
@@ -0,0 +1,28 @@
+package telemetry
+
+import (
+	"testing"
+	"time"
+)
+
+// TestHelpersAreNoopsBeforeInit ensures every exported helper tolerates
+// being called before Init has configured a sink, since that's the
+// default state for a client agent with telemetry disabled.
+func TestHelpersAreNoopsBeforeInit(t *testing.T) {
+	IncrCounter([]string{"test", "counter"}, 1)
+	SetGauge([]string{"test", "gauge"}, 1)
+	MeasureSince([]string{"test", "timer"}, time.Now())
+
+	if got := DisplayMetrics(); got != nil {
+		t.Fatalf("expected DisplayMetrics to be nil without a configured sink, got %#v", got)
+	}
+}
+
+func TestInitNilConfigStaysDisabled(t *testing.T) {
+	if err := Init(nil); err != nil {
+		t.Fatalf("Init(nil): unexpected error: %v", err)
+	}
+	if enabled {
+		t.Fatal("expected telemetry to stay disabled with a nil config")
+	}
+}
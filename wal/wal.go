@@ -0,0 +1,253 @@
+// Package wal is a small per-agent append-only log of outbound job
+// dispatch queries (QueryRunJob/QueryStopJob), keyed by target node, so
+// the leader can replay anything a node missed while partitioned instead
+// of relying solely on best-effort Serf gossip.
+package wal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// EntryType identifies which query an Entry replays.
+type EntryType int
+
+const (
+	EntryRunJob EntryType = iota
+	EntryStopJob
+)
+
+const segmentFile = "segment.log"
+
+// Entry is a single WAL record: a dispatch of one query to one target
+// node, tagged with a monotonically increasing sequence number.
+type Entry struct {
+	Seq    uint64          `json:"seq"`
+	Type   EntryType       `json:"type"`
+	Target string          `json:"target"`
+	Job    json.RawMessage `json:"job"`
+}
+
+// WAL is an append-only, per-agent log of job dispatch queries. It is
+// safe for concurrent use.
+type WAL struct {
+	mu   sync.Mutex
+	path string
+	seq  uint64
+	file *os.File
+
+	// truncated records, per target, the highest sequence number that has
+	// been acknowledged and can be dropped. Compact is what actually
+	// rewrites the segment to remove them; Truncate just records the mark,
+	// so draining a large backlog doesn't rewrite the whole file once per
+	// entry.
+	truncated map[string]uint64
+}
+
+// Open opens or creates a WAL rooted at dir, replaying the existing
+// segment file (if any) to recover the last sequence number used.
+func Open(dir string) (*WAL, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, segmentFile)
+	w := &WAL{path: path, truncated: make(map[string]uint64)}
+
+	if err := w.loadLastSeq(); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w.file = f
+
+	return w, nil
+}
+
+func (w *WAL) loadLastSeq() error {
+	f, err := os.Open(w.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if entry.Seq > w.seq {
+			w.seq = entry.Seq
+		}
+	}
+	return scanner.Err()
+}
+
+// Append records a new outbound dispatch query for target and returns its
+// assigned sequence number. It fsyncs before returning, so a crash right
+// after Append can't silently drop the entry it just wrote.
+func (w *WAL) Append(target string, typ EntryType, job interface{}) (uint64, error) {
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return 0, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.seq++
+	entry := Entry{Seq: w.seq, Type: typ, Target: target, Job: payload}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.file.Write(append(line, '\n')); err != nil {
+		return 0, err
+	}
+	if err := w.file.Sync(); err != nil {
+		return 0, err
+	}
+	return entry.Seq, nil
+}
+
+// Entries returns every recorded entry for target with a sequence number
+// greater than after, in the order they were appended.
+func (w *WAL) Entries(target string, after uint64) ([]Entry, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	f, err := os.Open(w.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if entry.Target == target && entry.Seq > after {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// Truncate marks every entry for target up to and including seq as
+// acknowledged and safe to drop. It only ever raises target's mark and is
+// O(1): the segment file isn't rewritten here, so replaying a large
+// backlog of entries to one target doesn't cost a full rewrite per entry.
+// The marks are applied to the file the next time Compact runs.
+func (w *WAL) Truncate(target string, seq uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if cur, ok := w.truncated[target]; !ok || seq > cur {
+		w.truncated[target] = seq
+	}
+	return nil
+}
+
+// Compact rewrites the segment file, dropping every entry made obsolete
+// by a prior Truncate call, across all targets, except the entry holding
+// the highest sequence number, which is kept so Open can still recover the
+// last sequence number used. Callers should invoke this periodically in
+// the background rather than after every Truncate.
+func (w *WAL) Compact() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.truncated) == 0 {
+		return nil
+	}
+
+	entries, err := w.readAllLocked()
+	if err != nil {
+		return err
+	}
+
+	tmp := w.path + ".compact"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if upTo, ok := w.truncated[entry.Target]; ok && entry.Seq <= upTo && entry.Seq != w.seq {
+			continue
+		}
+		line, err := json.Marshal(entry)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := f.Write(append(line, '\n')); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, w.path); err != nil {
+		return err
+	}
+
+	f, err = os.OpenFile(w.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = f
+	return nil
+}
+
+func (w *WAL) readAllLocked() ([]Entry, error) {
+	f, err := os.Open(w.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("wal: corrupt entry: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// Close closes the underlying segment file.
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
@@ -0,0 +1,142 @@
+package wal
+
+import (
+	"os"
+	"testing"
+)
+
+func tempWAL(t *testing.T) *WAL {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "wal-test")
+	if err != nil {
+		t.Fatalf("MkdirTemp: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { w.Close() })
+	return w
+}
+
+func TestAppendAssignsIncreasingSeq(t *testing.T) {
+	w := tempWAL(t)
+
+	seq1, err := w.Append("node1", EntryRunJob, map[string]string{"name": "job-a"})
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	seq2, err := w.Append("node1", EntryStopJob, map[string]string{"name": "job-b"})
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	if seq1 == 0 || seq2 <= seq1 {
+		t.Fatalf("expected strictly increasing sequence numbers, got %d then %d", seq1, seq2)
+	}
+}
+
+func TestEntriesFiltersByTargetAndAfter(t *testing.T) {
+	w := tempWAL(t)
+
+	w.Append("node1", EntryRunJob, "a")
+	seq2, _ := w.Append("node1", EntryRunJob, "b")
+	w.Append("node2", EntryRunJob, "c")
+
+	entries, err := w.Entries("node1", 0)
+	if err != nil {
+		t.Fatalf("Entries: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries for node1, got %d", len(entries))
+	}
+
+	entries, err = w.Entries("node1", seq2-1)
+	if err != nil {
+		t.Fatalf("Entries: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Seq != seq2 {
+		t.Fatalf("expected only the entry after seq %d, got %+v", seq2-1, entries)
+	}
+}
+
+func TestTruncateDoesNotAffectOtherTargets(t *testing.T) {
+	w := tempWAL(t)
+
+	seq1, _ := w.Append("node1", EntryRunJob, "a")
+	w.Append("node2", EntryRunJob, "b")
+
+	if err := w.Truncate("node1", seq1); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	if err := w.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+
+	node1Entries, err := w.Entries("node1", 0)
+	if err != nil {
+		t.Fatalf("Entries: %v", err)
+	}
+	if len(node1Entries) != 0 {
+		t.Fatalf("expected node1 entries to be compacted away, got %+v", node1Entries)
+	}
+
+	node2Entries, err := w.Entries("node2", 0)
+	if err != nil {
+		t.Fatalf("Entries: %v", err)
+	}
+	if len(node2Entries) != 1 {
+		t.Fatalf("expected node2's entry to survive compaction untouched, got %+v", node2Entries)
+	}
+}
+
+func TestCompactIsNoopWithoutTruncate(t *testing.T) {
+	w := tempWAL(t)
+	w.Append("node1", EntryRunJob, "a")
+
+	if err := w.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+
+	entries, err := w.Entries("node1", 0)
+	if err != nil {
+		t.Fatalf("Entries: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected untouched entry to survive a no-op compaction, got %+v", entries)
+	}
+}
+
+func TestOpenRecoversLastSeq(t *testing.T) {
+	dir, err := os.MkdirTemp("", "wal-test")
+	if err != nil {
+		t.Fatalf("MkdirTemp: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	seq, err := w.Append("node1", EntryRunJob, "a")
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	w.Close()
+
+	w2, err := Open(dir)
+	if err != nil {
+		t.Fatalf("re-Open: %v", err)
+	}
+	defer w2.Close()
+
+	next, err := w2.Append("node1", EntryRunJob, "b")
+	if err != nil {
+		t.Fatalf("Append after reopen: %v", err)
+	}
+	if next <= seq {
+		t.Fatalf("expected sequence numbers to keep increasing across reopen, got %d then %d", seq, next)
+	}
+}